@@ -18,6 +18,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -27,119 +28,183 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	types "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 )
 
+const (
+	// defaultRenewalFraction is the default renewal window: 1/3 of lifetime.
+	defaultRenewalFraction = 3
+
+	// oldCertSecretKey and oldKeySecretKey back up the pre-renewal cert/key.
+	oldCertSecretKey = "cert.old"
+	oldKeySecretKey  = "key.old"
+
+	// revokedCertsConfigMapName holds revoked certificate serial numbers.
+	revokedCertsConfigMapName = "tidb-operator-revoked-certs"
+)
+
+// CreateOptions configures a Create call.
+type CreateOptions struct {
+	Namespace  string
+	Instance   string
+	CommonName string
+	Hosts      []string
+	IPs        []string
+	Component  string
+	Suffix     string
+	// SignerName overrides the signer picked by defaultSignerName for the
+	// certificates.k8s.io/v1 API. A v1beta1 API server is not sent the
+	// name, but it still picks the requested usages and the checks made
+	// before submission.
+	SignerName string
+	// IssuerRef selects a pluggable Issuer backend instead of the
+	// default in-cluster CSR API. It is set only by the caller of Create;
+	// no TidbCluster field maps to it. Leave nil to keep using the CSR API.
+	IssuerRef *IssuerRef
+	// KeyConfig selects the private key algorithm generated for this
+	// certificate. The zero value defaults to certutil.DefaultKeyConfig
+	// (RSA-2048).
+	KeyConfig certutil.KeyConfig
+}
+
 // CertControlInterface manages certificates used by TiDB clusters
 type CertControlInterface interface {
-	Create(ns string, instance string, commonName string, hostList []string, IPList []string, component string, suffix string) error
+	Create(opts CreateOptions) error
+	WaitForCert(ns string, csrName string, timeout time.Duration) error
 	LoadFromSecret(ns string, secretName string) ([]byte, []byte, error)
 	SaveToSecret(ns string, instance string, component string, suffix string, cert []byte, key []byte) error
 	CheckSecret(ns string, secretName string) bool
-	//RevokeCert() error
-	//RenewCert() error
+	RenewCert(ns string, instance string, component string, suffix string) error
+	RevokeCert(ns string, instance string, component string, suffix string) error
 }
 
 type realCertControl struct {
-	kubeCli kubernetes.Interface
+	kubeCli  kubernetes.Interface
+	csr      csrClient
+	approver *csrApprover
+
+	// renewBeforeThreshold overrides the renewal window per component.
+	renewBeforeThreshold map[string]time.Duration
 }
 
-// NewRealCertControl creates a new CertControlInterface
+// csrSignTimeout bounds how long RenewCert waits for its CSR to be signed.
+const csrSignTimeout = 5 * time.Minute
+
+// NewRealCertControl creates a new CertControlInterface.
 func NewRealCertControl(
 	kubeCli kubernetes.Interface,
+	discoveryCli discovery.DiscoveryInterface,
 ) CertControlInterface {
+	version, err := selectCSRAPIVersion(discoveryCli)
+	if err != nil {
+		glog.Errorf("failed to discover certificates.k8s.io API version, falling back to v1beta1: %v", err)
+		version = "v1beta1"
+	}
+	csr := newCSRClientForVersion(kubeCli, version)
+
 	return &realCertControl{
-		kubeCli: kubeCli,
+		kubeCli:              kubeCli,
+		csr:                  csr,
+		approver:             newCSRApprover(kubeCli, csr, version),
+		renewBeforeThreshold: make(map[string]time.Duration),
 	}
 }
 
-func (rcc *realCertControl) Create(ns string, instance string, commonName string,
-	hostList []string, IPList []string, component string, suffix string) error {
-	var csrName string
+// RunCSRApprover starts the background CSR approver; it blocks until stopCh is closed.
+func (rcc *realCertControl) RunCSRApprover(workers int, stopCh <-chan struct{}) {
+	rcc.approver.Run(workers, stopCh)
+}
+
+// SetRenewBeforeThreshold overrides the renewal window for component.
+func (rcc *realCertControl) SetRenewBeforeThreshold(component string, threshold time.Duration) {
+	rcc.renewBeforeThreshold[component] = threshold
+}
+
+func secretName(instance string, suffix string) string {
 	if suffix == "" {
-		csrName = instance
-	} else {
-		csrName = fmt.Sprintf("%s-%s", instance, suffix)
+		return instance
 	}
+	return fmt.Sprintf("%s-%s", instance, suffix)
+}
+
+func (rcc *realCertControl) Create(opts CreateOptions) error {
+	csrName := secretName(opts.Instance, opts.Suffix)
 
 	// generate certificate if not exist
-	if rcc.CheckSecret(ns, csrName) {
+	if rcc.CheckSecret(opts.Namespace, csrName) {
 		// TODO: validate the cert and key
-		glog.Infof("Secret %s already exist, reusing the key pair. TidbCluster: %s/%s", csrName, ns, csrName)
+		glog.Infof("Secret %s already exist, reusing the key pair. TidbCluster: %s/%s", csrName, opts.Namespace, csrName)
 		return nil
 	}
 
-	rawCSR, key, err := certutil.NewCSR(commonName, hostList, IPList)
-	if err != nil {
-		return fmt.Errorf("fail to generate new key and certificate for %s/%s, %v", ns, csrName, err)
+	if rcc.secretExists(opts.Namespace, csrName) {
+		// The Secret is there but CheckSecret rejected it (due for
+		// renewal, revoked, or otherwise invalid); rotate it instead of
+		// falling through to a Create() that would hit AlreadyExists.
+		glog.Infof("Secret %s exists but is due for renewal, rotating. TidbCluster: %s/%s", csrName, opts.Namespace, csrName)
+		return rcc.RenewCert(opts.Namespace, opts.Instance, opts.Component, opts.Suffix)
 	}
 
-	// sign certificate
-	csr, err := rcc.sendCSR(ns, instance, rawCSR, csrName)
+	rawCSR, key, err := certutil.NewCSRWithConfig(opts.CommonName, opts.Hosts, opts.IPs, opts.KeyConfig)
 	if err != nil {
-		return err
-	}
-	err = rcc.approveCSR(csr)
-	if err != nil {
-		return err
+		return fmt.Errorf("fail to generate new key and certificate for %s/%s, %v", opts.Namespace, csrName, err)
 	}
 
-	// wait at most 5min for the cert to be signed
-	timeout := int64(time.Minute.Seconds() * 5)
-	tick := time.After(time.Second * 10)
-	watchReq := types.ListOptions{
-		Watch:          true,
-		TimeoutSeconds: &timeout,
-		FieldSelector:  fields.OneTermEqualSelector("metadata.name", csrName).String(),
+	signerName := opts.SignerName
+	if signerName == "" {
+		signerName = defaultSignerName(opts.Component)
 	}
+	usages := usagesForSigner(signerName)
 
-	csrCh, err := rcc.kubeCli.Certificates().CertificateSigningRequests().Watch(watchReq)
+	issuer, err := rcc.resolveIssuer(opts)
 	if err != nil {
-		glog.Errorf("error watch CSR for [%s/%s]: %s", ns, instance, csrName)
-		return err
+		return fmt.Errorf("failed to resolve issuer for %s/%s: %v", opts.Namespace, csrName, err)
+	}
+	if issuer != nil {
+		// Issuer backends are one-shot, so sign and save synchronously.
+		certPEM, chainPEM, err := issuer.Sign(rawCSR, IssuerProfile{
+			CommonName: opts.CommonName,
+			Hosts:      opts.Hosts,
+			IPs:        opts.IPs,
+			Usages:     usages,
+		})
+		if err != nil {
+			return fmt.Errorf("issuer failed to sign certificate for %s/%s: %v", opts.Namespace, csrName, err)
+		}
+		return rcc.saveIssuedSecret(opts, certPEM, chainPEM, key)
 	}
 
-	watchCh := csrCh.ResultChan()
-	for {
-		select {
-		case <-tick:
-			glog.Infof("CSR still not approved for [%s/%s]: %s, retry later", ns, instance, csrName)
-			continue
-		case event, ok := <-watchCh:
-			if !ok {
-				return fmt.Errorf("fail to get signed certificate for %s", csrName)
-			}
+	if err := validateAgainstSigner(signerName, opts.Hosts, opts.IPs, usages); err != nil {
+		return fmt.Errorf("CSR for %s/%s rejected for signer %s: %v", opts.Namespace, csrName, signerName, err)
+	}
 
-			if len(event.Object.(*capi.CertificateSigningRequest).Status.Conditions) == 0 {
-				continue
-			}
+	// Register before submission so the approver can't race us.
+	rcc.approver.registerPending(csrName, func(cert []byte) error {
+		return rcc.SaveToSecret(opts.Namespace, opts.Instance, opts.Component, opts.Suffix, cert, key)
+	})
+	rcc.approver.registerWaiter(csrName)
 
-			updatedCSR := event.Object.(*capi.CertificateSigningRequest)
-			approveCond := updatedCSR.Status.Conditions[len(csr.Status.Conditions)-1].Type
+	if _, err := rcc.sendCSR(opts.Namespace, opts.Instance, rawCSR, csrName, signerName, usages); err != nil {
+		rcc.approver.cancel(csrName)
+		return err
+	}
 
-			if updatedCSR.UID == csr.UID &&
-				approveCond == capi.CertificateApproved &&
-				updatedCSR.Status.Certificate != nil {
-				glog.Infof("signed certificate for [%s/%s]: %s", ns, instance, csrName)
+	// A new CSR is not waited for; use WaitForCert to block for the result.
+	// An existing Secret that needs rotating goes through RenewCert above,
+	// which does wait for the signed certificate.
+	return nil
+}
 
-				// save signed certificate and key to secret
-				err = rcc.SaveToSecret(ns, instance, component, suffix, updatedCSR.Status.Certificate, key)
-				if err == nil {
-					// cleanup the approved csr
-					delOpts := &types.DeleteOptions{TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"}}
-					return rcc.kubeCli.Certificates().CertificateSigningRequests().Delete(csrName, delOpts)
-				}
-				return err
-			}
-			continue
-		}
-	}
+// WaitForCert blocks until the CSR named csrName is signed or timeout elapses.
+func (rcc *realCertControl) WaitForCert(ns string, csrName string, timeout time.Duration) error {
+	return rcc.approver.WaitForCert(ns, csrName, timeout)
 }
 
-func (rcc *realCertControl) getCSR(ns string, instance string, csrName string) (*capi.CertificateSigningRequest, error) {
-	getOpts := types.GetOptions{TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"}}
-	csr, err := rcc.kubeCli.CertificatesV1beta1().CertificateSigningRequests().Get(csrName, getOpts)
+func (rcc *realCertControl) getCSR(ns string, instance string, csrName string) (*csrHandle, error) {
+	handle, err := rcc.csr.Get(csrName)
 	if err != nil && apierrors.IsNotFound(err) {
 		// it's supposed to be not found
 		return nil, nil
@@ -150,89 +215,254 @@ func (rcc *realCertControl) getCSR(ns string, instance string, csrName string) (
 	}
 
 	labelTemp := label.New()
-	if csr.Labels[label.NamespaceLabelKey] == ns &&
-		csr.Labels[label.ManagedByLabelKey] == labelTemp[label.ManagedByLabelKey] &&
-		csr.Labels[label.InstanceLabelKey] == instance {
-		return csr, nil
+	if handle.Labels[label.NamespaceLabelKey] == ns &&
+		handle.Labels[label.ManagedByLabelKey] == labelTemp[label.ManagedByLabelKey] &&
+		handle.Labels[label.InstanceLabelKey] == instance {
+		return handle, nil
 	}
 	return nil, fmt.Errorf("CSR %s/%s already exist, but not created by tidb-operator, skip it", ns, csrName)
 }
 
-func (rcc *realCertControl) sendCSR(ns string, instance string, rawCSR []byte, csrName string) (*capi.CertificateSigningRequest, error) {
-	var csr *capi.CertificateSigningRequest
-
+func (rcc *realCertControl) sendCSR(ns string, instance string, rawCSR []byte, csrName string, signerName string, usages []capi.KeyUsage) (*csrHandle, error) {
 	// check for exist CSR, overwirte if it was created by operator, otherwise block the process
-	csr, err := rcc.getCSR(ns, instance, csrName)
+	existing, err := rcc.getCSR(ns, instance, csrName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CSR for [%s/%s]: %s, error: %v", ns, instance, csrName, err)
 	}
 
-	if csr != nil {
+	if existing != nil {
 		glog.Infof("found exist CSR %s/%s created by tidb-operator, overwriting", ns, csrName)
-		delOpts := &types.DeleteOptions{TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"}}
-		err := rcc.kubeCli.Certificates().CertificateSigningRequests().Delete(csrName, delOpts)
-		if err != nil {
+		if err := rcc.csr.Delete(csrName); err != nil {
 			return nil, fmt.Errorf("failed to delete exist old CSR for [%s/%s]: %s, error: %v", ns, instance, csrName, err)
 		}
 		glog.Infof("exist old CSR deleted for [%s/%s]: %s", ns, instance, csrName)
-		return rcc.sendCSR(ns, instance, rawCSR, csrName)
-	}
-
-	csr = &capi.CertificateSigningRequest{
-		TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"},
-		ObjectMeta: types.ObjectMeta{
-			Name:   csrName,
-			Labels: make(map[string]string),
-		},
-		Spec: capi.CertificateSigningRequestSpec{
-			Request: pem.EncodeToMemory(&pem.Block{
-				Type:    "CERTIFICATE REQUEST",
-				Headers: nil,
-				Bytes:   rawCSR,
-			}),
-			Usages: []capi.KeyUsage{
-				capi.UsageClientAuth,
-				capi.UsageServerAuth,
-			},
-		},
+		return rcc.sendCSR(ns, instance, rawCSR, csrName, signerName, usages)
 	}
 
 	labelTemp := label.New()
-	csr.Labels[label.NamespaceLabelKey] = ns
-	csr.Labels[label.ManagedByLabelKey] = labelTemp[label.ManagedByLabelKey]
-	csr.Labels[label.InstanceLabelKey] = instance
+	csrLabels := map[string]string{
+		label.NamespaceLabelKey: ns,
+		label.ManagedByLabelKey: labelTemp[label.ManagedByLabelKey],
+		label.InstanceLabelKey:  instance,
+	}
 
-	resp, err := rcc.kubeCli.CertificatesV1beta1().CertificateSigningRequests().Create(csr)
+	handle, err := rcc.csr.Create(&csrRequest{
+		Name:   csrName,
+		Labels: csrLabels,
+		PEMRequest: pem.EncodeToMemory(&pem.Block{
+			Type:    "CERTIFICATE REQUEST",
+			Headers: nil,
+			Bytes:   rawCSR,
+		}),
+		SignerName: signerName,
+		Usages:     usages,
+	})
 	if err != nil {
-		return resp, fmt.Errorf("failed to create CSR for [%s/%s]: %s, error: %v", ns, instance, csrName, err)
+		return nil, fmt.Errorf("failed to create CSR for [%s/%s]: %s, error: %v", ns, instance, csrName, err)
 	}
 	glog.Infof("CSR created for [%s/%s]: %s", ns, instance, csrName)
-	return resp, nil
+	return handle, nil
 }
 
-func (rcc *realCertControl) approveCSR(csr *capi.CertificateSigningRequest) error {
-	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
-		Type:    capi.CertificateApproved,
-		Reason:  "AutoApproved",
-		Message: "Auto approved by TiDB Operator",
+// RenewCert rotates the cert/key pair backing the named Secret ahead of expiry.
+func (rcc *realCertControl) RenewCert(ns string, instance string, component string, suffix string) error {
+	name := secretName(instance, suffix)
+
+	oldCertBytes, oldKeyBytes, err := rcc.LoadFromSecret(ns, name)
+	if err != nil {
+		return fmt.Errorf("failed to load secret %s/%s for renewal: %v", ns, name, err)
+	}
+
+	block, _ := pem.Decode(oldCertBytes)
+	if block == nil {
+		return fmt.Errorf("failed to decode existing certificate in secret %s/%s for renewal", ns, name)
+	}
+	oldCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse existing certificate in secret %s/%s for renewal: %v", ns, name, err)
+	}
+
+	var ipList []string
+	for _, ip := range oldCert.IPAddresses {
+		ipList = append(ipList, ip.String())
+	}
+
+	// Keep the key algorithm the cert being replaced was issued with.
+	keyConfig := certutil.DefaultKeyConfig
+	if oldKeyBlock, _ := pem.Decode(oldKeyBytes); oldKeyBlock != nil {
+		if oldKey, err := certutil.ParsePrivateKey(oldKeyBlock.Bytes); err == nil {
+			keyConfig = certutil.KeyConfigFor(oldKey)
+		}
+	}
+
+	rawCSR, key, err := certutil.NewCSRWithConfig(oldCert.Subject.CommonName, oldCert.DNSNames, ipList, keyConfig)
+	if err != nil {
+		return fmt.Errorf("failed to generate renewal key and CSR for %s/%s: %v", ns, name, err)
+	}
+
+	csrName := fmt.Sprintf("%s-renew-%d", name, time.Now().Unix())
+	signerName := defaultSignerName(component)
+	usages := usagesForSigner(signerName)
+
+	rcc.approver.registerPending(csrName, func(cert []byte) error {
+		return rcc.rotateSecret(ns, name, oldCertBytes, oldKeyBytes, cert, key)
 	})
-	_, err := rcc.kubeCli.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(csr)
+	rcc.approver.registerWaiter(csrName)
+
+	if _, err := rcc.sendCSR(ns, instance, rawCSR, csrName, signerName, usages); err != nil {
+		rcc.approver.cancel(csrName)
+		return err
+	}
+
+	// unlike a fresh Create, RenewCert blocks: callers renewing a cert expect the
+	// rotation to be done (or to have failed) by the time it returns.
+	return rcc.approver.WaitForCert(ns, csrName, csrSignTimeout)
+}
+
+// rotateSecret atomically swaps an existing Secret's cert/key pair.
+func (rcc *realCertControl) rotateSecret(ns string, name string, oldCert []byte, oldKey []byte, newCert []byte, newKey []byte) error {
+	secret, err := rcc.kubeCli.CoreV1().Secrets(ns).Get(name, types.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("error updating approval for csr: %v", err)
+		return fmt.Errorf("failed to get secret %s/%s for renewal: %v", ns, name, err)
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data["cert"] = newCert
+	secret.Data["key"] = newKey
+	secret.Data[oldCertSecretKey] = oldCert
+	secret.Data[oldKeySecretKey] = oldKey
+
+	if _, err := rcc.kubeCli.CoreV1().Secrets(ns).Update(secret); err != nil {
+		return fmt.Errorf("failed to save renewed certificate to secret %s/%s: %v", ns, name, err)
 	}
+	glog.Infof("renewed certificate for secret %s/%s", ns, name)
 	return nil
 }
 
-/*
-func (rcc *realCertControl) RevokeCert() error {
+// RevokeCert deletes the Secret and records its serial as revoked.
+func (rcc *realCertControl) RevokeCert(ns string, instance string, component string, suffix string) error {
+	name := secretName(instance, suffix)
+
+	if certBytes, _, err := rcc.LoadFromSecret(ns, name); err == nil {
+		if block, _ := pem.Decode(certBytes); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				if err := rcc.recordRevokedSerial(ns, cert.SerialNumber.String()); err != nil {
+					return fmt.Errorf("failed to record revocation for %s/%s: %v", ns, name, err)
+				}
+			}
+		}
+	}
+
+	secretDelOpts := &types.DeleteOptions{TypeMeta: types.TypeMeta{Kind: "Secret"}}
+	if err := rcc.kubeCli.CoreV1().Secrets(ns).Delete(name, secretDelOpts); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s/%s on revocation: %v", ns, name, err)
+	}
+
+	if err := rcc.csr.Delete(name); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete lingering CSR %s/%s on revocation: %v", ns, name, err)
+	}
+
+	glog.Infof("revoked certificate for [%s/%s]: %s", ns, instance, name)
 	return nil
 }
-*/
-/*
-func (rcc *realCertControl) RenewCert() error {
-	return nil
+
+// recordRevokedSerial appends serial to the namespaced revoked-certs ConfigMap.
+func (rcc *realCertControl) recordRevokedSerial(ns string, serial string) error {
+	cm, err := rcc.kubeCli.CoreV1().ConfigMaps(ns).Get(revokedCertsConfigMapName, types.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: types.ObjectMeta{
+				Name:      revokedCertsConfigMapName,
+				Namespace: ns,
+			},
+			Data: map[string]string{
+				serial: time.Now().Format(time.RFC3339),
+			},
+		}
+		_, err = rcc.kubeCli.CoreV1().ConfigMaps(ns).Create(cm)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[serial] = time.Now().Format(time.RFC3339)
+	_, err = rcc.kubeCli.CoreV1().ConfigMaps(ns).Update(cm)
+	return err
+}
+
+// isRevoked reports whether serial appears in the revoked-certs ConfigMap.
+func (rcc *realCertControl) isRevoked(ns string, serial string) bool {
+	cm, err := rcc.kubeCli.CoreV1().ConfigMaps(ns).Get(revokedCertsConfigMapName, types.GetOptions{})
+	if err != nil {
+		return false
+	}
+	_, ok := cm.Data[serial]
+	return ok
+}
+
+// needsRenewal reports whether cert is within its renewal window for component.
+func (rcc *realCertControl) needsRenewal(cert *x509.Certificate, component string) bool {
+	threshold := rcc.renewBeforeThreshold[component]
+	if threshold <= 0 {
+		lifetime := cert.NotAfter.Sub(cert.NotBefore)
+		threshold = lifetime / defaultRenewalFraction
+	}
+	return time.Until(cert.NotAfter) < threshold
+}
+
+// RunCertRenewalController periodically renews expiring certs in ns; blocks until stopCh is closed.
+func (rcc *realCertControl) RunCertRenewalController(ns string, resync time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		rcc.renewExpiringCerts(ns)
+	}, resync, stopCh)
+}
+
+func (rcc *realCertControl) renewExpiringCerts(ns string) {
+	labelTemp := label.New()
+	selector := labels.SelectorFromSet(labels.Set{label.ManagedByLabelKey: labelTemp[label.ManagedByLabelKey]})
+	secrets, err := rcc.kubeCli.CoreV1().Secrets(ns).List(types.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		glog.Errorf("failed to list managed cert secrets in %s for renewal check: %v", ns, err)
+		return
+	}
+
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		instance := secret.Labels[label.InstanceLabelKey]
+		if instance == "" {
+			continue
+		}
+		if _, ok := secret.Data["cert"]; !ok {
+			// Not a cert Secret produced by SaveToSecret -- some other
+			// managed-by-operator Secret sharing these labels.
+			continue
+		}
+		if rcc.CheckSecret(secret.Namespace, secret.Name) {
+			continue
+		}
+		component := secret.Labels[label.ComponentLabelKey]
+		suffix := strings.TrimPrefix(secret.Name, instance+"-")
+		if suffix == secret.Name {
+			suffix = ""
+		}
+		glog.Infof("certificate %s/%s is due for renewal", secret.Namespace, secret.Name)
+		if err := rcc.RenewCert(secret.Namespace, instance, component, suffix); err != nil {
+			glog.Errorf("failed to renew certificate %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+	}
+}
+
+// secretExists reports whether a Secret named name exists in ns.
+func (rcc *realCertControl) secretExists(ns string, name string) bool {
+	_, err := rcc.kubeCli.CoreV1().Secrets(ns).Get(name, types.GetOptions{})
+	return err == nil
 }
-*/
 
 // LoadFromSecret loads cert and key from Secret matching the name
 func (rcc *realCertControl) LoadFromSecret(ns string, secretName string) ([]byte, []byte, error) {
@@ -245,11 +475,11 @@ func (rcc *realCertControl) LoadFromSecret(ns string, secretName string) ([]byte
 }
 
 func (rcc *realCertControl) SaveToSecret(ns string, instance string, component string, suffix string, cert []byte, key []byte) error {
-	secretName := fmt.Sprintf("%s-%s", instance, suffix)
+	name := secretName(instance, suffix)
 
 	secret := &corev1.Secret{
 		ObjectMeta: types.ObjectMeta{
-			Name:   secretName,
+			Name:   name,
 			Labels: make(map[string]string),
 		},
 		Data: map[string][]byte{
@@ -265,21 +495,41 @@ func (rcc *realCertControl) SaveToSecret(ns string, instance string, component s
 	secret.Labels[label.ComponentLabelKey] = component
 
 	_, err := rcc.kubeCli.CoreV1().Secrets(ns).Create(secret)
-	glog.Infof("save cert to secret %s/%s, error: %v", ns, secretName, err)
+	glog.Infof("save cert to secret %s/%s, error: %v", ns, name, err)
+	return err
+}
+
+// saveIssuedSecret saves a cert/key pair signed by a pluggable Issuer, plus its chain under ca.crt.
+func (rcc *realCertControl) saveIssuedSecret(opts CreateOptions, certPEM []byte, chainPEM []byte, key []byte) error {
+	if err := rcc.SaveToSecret(opts.Namespace, opts.Instance, opts.Component, opts.Suffix, certPEM, key); err != nil {
+		return err
+	}
+	if len(chainPEM) == 0 {
+		return nil
+	}
+
+	name := secretName(opts.Instance, opts.Suffix)
+	secret, err := rcc.kubeCli.CoreV1().Secrets(opts.Namespace).Get(name, types.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to load secret %s/%s to persist issuer chain: %v", opts.Namespace, name, err)
+	}
+	secret.Data["ca.crt"] = chainPEM
+	_, err = rcc.kubeCli.CoreV1().Secrets(opts.Namespace).Update(secret)
 	return err
 }
 
 // CheckSecret returns true if the secret already exist
 func (rcc *realCertControl) CheckSecret(ns string, secretName string) bool {
-	certBytes, keyBytes, err := rcc.LoadFromSecret(ns, secretName)
+	secret, err := rcc.kubeCli.CoreV1().Secrets(ns).Get(secretName, types.GetOptions{})
 	if err != nil {
 		return false
 	}
+	certBytes, keyBytes := secret.Data["cert"], secret.Data["key"]
 
 	// validate if the certificate is valid
 	block, _ := pem.Decode(certBytes)
 	if block == nil {
-		glog.Errorf("certificate validation failed for [%s/%s], can not decode cert to PEM", ns, secretName, err)
+		glog.Errorf("certificate validation failed for [%s/%s], can not decode cert to PEM", ns, secretName)
 		return false
 	}
 	cert, err := x509.ParseCertificate(block.Bytes)
@@ -313,6 +563,16 @@ func (rcc *realCertControl) CheckSecret(ns string, secretName string) bool {
 		return false
 	}
 
+	if rcc.isRevoked(ns, cert.SerialNumber.String()) {
+		glog.Infof("certificate for [%s/%s] has been revoked, renewal required", ns, secretName)
+		return false
+	}
+
+	if rcc.needsRenewal(cert, secret.Labels[label.ComponentLabelKey]) {
+		glog.Infof("certificate for [%s/%s] is within its renewal window, renewal required", ns, secretName)
+		return false
+	}
+
 	return true
 }
 
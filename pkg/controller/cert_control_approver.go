@@ -0,0 +1,257 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pingcap/tidb-operator/pkg/label"
+	capiv1 "k8s.io/api/certificates/v1"
+	capi "k8s.io/api/certificates/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	types "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// pendingCSR is registered before a CSR is submitted.
+type pendingCSR struct {
+	write func(cert []byte) error
+}
+
+// csrApprover auto-approves operator-owned CSRs and finishes provisioning.
+type csrApprover struct {
+	csr      csrClient
+	informer cache.SharedIndexInformer
+	queue    workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	pending map[string]pendingCSR
+	waiters map[string]chan error
+}
+
+// newCSRApprover builds a csrApprover for the given CSR API version.
+func newCSRApprover(kubeCli kubernetes.Interface, csr csrClient, version string) *csrApprover {
+	labelTemp := label.New()
+	selector := fmt.Sprintf("%s=%s", label.ManagedByLabelKey, labelTemp[label.ManagedByLabelKey])
+
+	a := &csrApprover{
+		csr:     csr,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "tidb-operator-csr-approver"),
+		pending: make(map[string]pendingCSR),
+		waiters: make(map[string]chan error),
+	}
+
+	a.informer = cache.NewSharedIndexInformer(
+		newCSRListWatch(kubeCli, version, selector),
+		csrExampleObject(version),
+		30*time.Minute,
+		cache.Indexers{},
+	)
+	a.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    a.enqueue,
+		UpdateFunc: func(_, cur interface{}) { a.enqueue(cur) },
+	})
+
+	return a
+}
+
+func csrExampleObject(version string) runtime.Object {
+	if version == "v1" {
+		return &capiv1.CertificateSigningRequest{}
+	}
+	return &capi.CertificateSigningRequest{}
+}
+
+func newCSRListWatch(kubeCli kubernetes.Interface, version string, labelSelector string) *cache.ListWatch {
+	if version == "v1" {
+		return &cache.ListWatch{
+			ListFunc: func(options types.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = labelSelector
+				return kubeCli.CertificatesV1().CertificateSigningRequests().List(options)
+			},
+			WatchFunc: func(options types.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = labelSelector
+				options.Watch = true
+				return kubeCli.CertificatesV1().CertificateSigningRequests().Watch(options)
+			},
+		}
+	}
+	return &cache.ListWatch{
+		ListFunc: func(options types.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = labelSelector
+			return kubeCli.CertificatesV1beta1().CertificateSigningRequests().List(options)
+		},
+		WatchFunc: func(options types.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = labelSelector
+			options.Watch = true
+			return kubeCli.CertificatesV1beta1().CertificateSigningRequests().Watch(options)
+		},
+	}
+}
+
+func (a *csrApprover) enqueue(obj interface{}) {
+	runtimeObj, ok := obj.(runtime.Object)
+	if !ok {
+		return
+	}
+	handle, ok := a.csr.Decode(runtimeObj)
+	if !ok {
+		return
+	}
+	a.queue.Add(handle.Name)
+}
+
+// registerPending records how to finish provisioning CSR name.
+func (a *csrApprover) registerPending(name string, write func(cert []byte) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending[name] = pendingCSR{write: write}
+}
+
+// registerWaiter returns the channel WaitForCert will block on for name.
+func (a *csrApprover) registerWaiter(name string) chan error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ch := make(chan error, 1)
+	a.waiters[name] = ch
+	return ch
+}
+
+// cancel drops any pending/waiter state registered for name.
+func (a *csrApprover) cancel(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, name)
+	if ch, ok := a.waiters[name]; ok {
+		close(ch)
+		delete(a.waiters, name)
+	}
+}
+
+// WaitForCert blocks until csrName is signed or timeout elapses.
+func (a *csrApprover) WaitForCert(ns string, csrName string, timeout time.Duration) error {
+	a.mu.Lock()
+	ch, ok := a.waiters[csrName]
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no pending certificate request registered for %s/%s", ns, csrName)
+	}
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(timeout):
+		a.mu.Lock()
+		delete(a.waiters, csrName)
+		a.mu.Unlock()
+		return fmt.Errorf("timed out waiting for certificate %s/%s", ns, csrName)
+	}
+}
+
+// Run starts the informer and worker pool; it blocks until stopCh is closed.
+func (a *csrApprover) Run(workers int, stopCh <-chan struct{}) {
+	defer a.queue.ShutDown()
+
+	go a.informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, a.informer.HasSynced) {
+		glog.Errorf("timed out waiting for CSR informer cache to sync")
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(a.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+}
+
+func (a *csrApprover) runWorker() {
+	for a.processNextItem() {
+	}
+}
+
+func (a *csrApprover) processNextItem() bool {
+	key, quit := a.queue.Get()
+	if quit {
+		return false
+	}
+	defer a.queue.Done(key)
+
+	if err := a.sync(key.(string)); err != nil {
+		glog.Errorf("error syncing CSR %s, requeuing: %v", key, err)
+		a.queue.AddRateLimited(key)
+		return true
+	}
+	a.queue.Forget(key)
+	return true
+}
+
+func (a *csrApprover) sync(name string) error {
+	handle, err := a.csr.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if handle.Certificate != nil {
+		return a.finish(name, handle)
+	}
+
+	if !handle.Approved {
+		if err := a.csr.Approve(name); err != nil {
+			return fmt.Errorf("error updating approval for csr: %v", err)
+		}
+	}
+	return nil
+}
+
+// finish hands the signed certificate to the registered pendingCSR; the
+// entry is only cleared once write succeeds, so a failure gets retried.
+func (a *csrApprover) finish(name string, handle *csrHandle) error {
+	a.mu.Lock()
+	p, ok := a.pending[name]
+	a.mu.Unlock()
+
+	if !ok {
+		glog.Infof("signed certificate observed for CSR %s with no pending registration (likely after a restart); leaving it for the owning controller to resubmit", name)
+		return nil
+	}
+
+	err := p.write(handle.Certificate)
+	if err == nil {
+		err = a.csr.Delete(name)
+	}
+
+	a.mu.Lock()
+	if err == nil {
+		delete(a.pending, name)
+	}
+	if ch, ok := a.waiters[name]; ok {
+		ch <- err
+		close(ch)
+		delete(a.waiters, name)
+	}
+	a.mu.Unlock()
+
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	types "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newFakeCertControl() *realCertControl {
+	return &realCertControl{
+		kubeCli:              fake.NewSimpleClientset(),
+		renewBeforeThreshold: make(map[string]time.Duration),
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Now()
+	cert := &x509.Certificate{
+		NotBefore: now.Add(-2 * time.Hour),
+		NotAfter:  now.Add(2 * time.Hour),
+	}
+
+	rcc := newFakeCertControl()
+	if rcc.needsRenewal(cert, "tikv") {
+		t.Fatalf("expected no renewal needed with 2h left of a 4h lifetime")
+	}
+
+	cert.NotAfter = now.Add(30 * time.Minute)
+	if !rcc.needsRenewal(cert, "tikv") {
+		t.Fatalf("expected renewal needed once inside the default 1/3-lifetime window")
+	}
+
+	rcc.SetRenewBeforeThreshold("pd", 3*time.Hour)
+	cert.NotAfter = now.Add(2 * time.Hour)
+	if !rcc.needsRenewal(cert, "pd") {
+		t.Fatalf("expected per-component threshold to override the default fraction")
+	}
+}
+
+func TestRecordRevokedSerialAndIsRevoked(t *testing.T) {
+	rcc := newFakeCertControl()
+
+	if rcc.isRevoked("ns1", "1234") {
+		t.Fatalf("serial should not be revoked before it's recorded")
+	}
+
+	if err := rcc.recordRevokedSerial("ns1", "1234"); err != nil {
+		t.Fatalf("recordRevokedSerial returned error: %v", err)
+	}
+	if !rcc.isRevoked("ns1", "1234") {
+		t.Fatalf("expected serial 1234 to be revoked after recording it")
+	}
+	if rcc.isRevoked("ns2", "1234") {
+		t.Fatalf("revocation should be namespaced, ns2 should be unaffected")
+	}
+
+	if err := rcc.recordRevokedSerial("ns1", "5678"); err != nil {
+		t.Fatalf("recordRevokedSerial returned error: %v", err)
+	}
+	if !rcc.isRevoked("ns1", "1234") || !rcc.isRevoked("ns1", "5678") {
+		t.Fatalf("expected both serials to remain revoked after a second write")
+	}
+}
+
+func TestRotateSecretKeepsRollbackKeys(t *testing.T) {
+	rcc := newFakeCertControl()
+
+	secret := &corev1.Secret{
+		ObjectMeta: types.ObjectMeta{Name: "demo", Namespace: "ns1"},
+		Data: map[string][]byte{
+			"cert": []byte("old-cert"),
+			"key":  []byte("old-key"),
+		},
+	}
+	if _, err := rcc.kubeCli.CoreV1().Secrets("ns1").Create(secret); err != nil {
+		t.Fatalf("failed to seed secret: %v", err)
+	}
+
+	if err := rcc.rotateSecret("ns1", "demo", []byte("old-cert"), []byte("old-key"), []byte("new-cert"), []byte("new-key")); err != nil {
+		t.Fatalf("rotateSecret returned error: %v", err)
+	}
+
+	got, err := rcc.kubeCli.CoreV1().Secrets("ns1").Get("demo", types.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to fetch rotated secret: %v", err)
+	}
+	if string(got.Data["cert"]) != "new-cert" || string(got.Data["key"]) != "new-key" {
+		t.Fatalf("rotateSecret did not update cert/key: %v", got.Data)
+	}
+	if string(got.Data[oldCertSecretKey]) != "old-cert" || string(got.Data[oldKeySecretKey]) != "old-key" {
+		t.Fatalf("rotateSecret did not preserve rollback keys: %v", got.Data)
+	}
+}
+
+func TestSecretExists(t *testing.T) {
+	rcc := newFakeCertControl()
+
+	if rcc.secretExists("ns1", "demo") {
+		t.Fatalf("secretExists should be false before the secret is created")
+	}
+
+	secret := &corev1.Secret{ObjectMeta: types.ObjectMeta{Name: "demo", Namespace: "ns1"}}
+	if _, err := rcc.kubeCli.CoreV1().Secrets("ns1").Create(secret); err != nil {
+		t.Fatalf("failed to seed secret: %v", err)
+	}
+	if !rcc.secretExists("ns1", "demo") {
+		t.Fatalf("secretExists should be true once the secret is created")
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+
+	capiv1 "k8s.io/api/certificates/v1"
+	capi "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	types "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// signerKubeletServing is the default v1 signer for server certs
+	// (components that terminate TLS: tikv/tidb/pd).
+	signerKubeletServing = "kubernetes.io/kubelet-serving"
+	// signerKubeAPIServerClient is the default v1 signer for client certs.
+	signerKubeAPIServerClient = "kubernetes.io/kube-apiserver-client"
+
+	certificatesGroupName = "certificates.k8s.io"
+)
+
+// csrRequest is the version-agnostic shape of a CSR submission.
+type csrRequest struct {
+	Name       string
+	Labels     map[string]string
+	PEMRequest []byte
+	// SignerName is only honored by the v1 implementation; v1beta1 has
+	// no equivalent field and ignores it.
+	SignerName string
+	// Usages are the key usages to request; use usagesForSigner(SignerName)
+	// so a v1 CSR only asks for what that signer allows.
+	Usages []capi.KeyUsage
+}
+
+// csrHandle is a version-agnostic view onto a CertificateSigningRequest.
+type csrHandle struct {
+	Name        string
+	UID         types.UID
+	Labels      map[string]string
+	Approved    bool
+	Certificate []byte
+}
+
+// csrClient abstracts CSR create/approve/delete across the
+// certificates.k8s.io v1 and v1beta1 APIs.
+type csrClient interface {
+	Create(req *csrRequest) (*csrHandle, error)
+	Get(name string) (*csrHandle, error)
+	// Decode converts a raw informer/watch event object into a csrHandle;
+	// ok is false if obj isn't the version this client handles.
+	Decode(obj runtime.Object) (handle *csrHandle, ok bool)
+	Approve(name string) error
+	Delete(name string) error
+}
+
+// defaultSignerName picks the certificates.k8s.io/v1 signer for component.
+func defaultSignerName(component string) string {
+	switch component {
+	case "tikv", "tidb", "pd":
+		return signerKubeletServing
+	default:
+		return signerKubeAPIServerClient
+	}
+}
+
+// usagesForSigner returns the exact usage set the built-in v1 signers expect.
+func usagesForSigner(signerName string) []capi.KeyUsage {
+	switch signerName {
+	case signerKubeletServing:
+		return []capi.KeyUsage{capi.UsageServerAuth}
+	case signerKubeAPIServerClient:
+		return []capi.KeyUsage{capi.UsageClientAuth}
+	default:
+		return []capi.KeyUsage{capi.UsageClientAuth, capi.UsageServerAuth}
+	}
+}
+
+func hasUsage(usages []capi.KeyUsage, want capi.KeyUsage) bool {
+	for _, u := range usages {
+		if u == want {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAgainstSigner rejects CSR subjects/usages the chosen v1 signer would refuse.
+func validateAgainstSigner(signerName string, hostList []string, ipList []string, usages []capi.KeyUsage) error {
+	switch signerName {
+	case signerKubeletServing:
+		if len(hostList) == 0 && len(ipList) == 0 {
+			return fmt.Errorf("signer %s requires at least one DNS or IP SAN", signerName)
+		}
+		if !hasUsage(usages, capi.UsageServerAuth) {
+			return fmt.Errorf("signer %s requires the %q usage", signerName, capi.UsageServerAuth)
+		}
+		if hasUsage(usages, capi.UsageClientAuth) {
+			return fmt.Errorf("signer %s does not allow the %q usage", signerName, capi.UsageClientAuth)
+		}
+	case signerKubeAPIServerClient:
+		if !hasUsage(usages, capi.UsageClientAuth) {
+			return fmt.Errorf("signer %s requires the %q usage", signerName, capi.UsageClientAuth)
+		}
+		if hasUsage(usages, capi.UsageServerAuth) {
+			return fmt.Errorf("signer %s does not allow the %q usage", signerName, capi.UsageServerAuth)
+		}
+	}
+	return nil
+}
+
+// selectCSRAPIVersion discovers whether the API server exposes certificates.k8s.io/v1.
+func selectCSRAPIVersion(discoveryCli discovery.DiscoveryInterface) (string, error) {
+	groups, err := discoveryCli.ServerGroups()
+	if err != nil {
+		return "", fmt.Errorf("failed to discover server API groups: %v", err)
+	}
+	for _, g := range groups.Groups {
+		if g.Name != certificatesGroupName {
+			continue
+		}
+		for _, v := range g.Versions {
+			if v.Version == "v1" {
+				return "v1", nil
+			}
+		}
+	}
+	return "v1beta1", nil
+}
+
+// newCSRClientForVersion builds the csrClient implementation matching version.
+func newCSRClientForVersion(kubeCli kubernetes.Interface, version string) csrClient {
+	if version == "v1" {
+		return &csrV1Client{cli: kubeCli}
+	}
+	return &csrV1beta1Client{cli: kubeCli}
+}
+
+// csrV1beta1Client implements csrClient against certificates.k8s.io/v1beta1.
+type csrV1beta1Client struct {
+	cli kubernetes.Interface
+}
+
+func (c *csrV1beta1Client) Create(req *csrRequest) (*csrHandle, error) {
+	usages := req.Usages
+	if len(usages) == 0 {
+		usages = []capi.KeyUsage{capi.UsageClientAuth, capi.UsageServerAuth}
+	}
+
+	csr := &capi.CertificateSigningRequest{
+		TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"},
+		ObjectMeta: types.ObjectMeta{
+			Name:   req.Name,
+			Labels: req.Labels,
+		},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request: req.PEMRequest,
+			Usages:  usages,
+		},
+	}
+	resp, err := c.cli.CertificatesV1beta1().CertificateSigningRequests().Create(csr)
+	if err != nil {
+		return nil, err
+	}
+	return csrV1beta1ToHandle(resp), nil
+}
+
+func (c *csrV1beta1Client) Get(name string) (*csrHandle, error) {
+	resp, err := c.cli.CertificatesV1beta1().CertificateSigningRequests().Get(name, types.GetOptions{TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"}})
+	if err != nil {
+		return nil, err
+	}
+	return csrV1beta1ToHandle(resp), nil
+}
+
+func (c *csrV1beta1Client) Decode(obj runtime.Object) (*csrHandle, bool) {
+	csr, ok := obj.(*capi.CertificateSigningRequest)
+	if !ok {
+		return nil, false
+	}
+	return csrV1beta1ToHandle(csr), true
+}
+
+func (c *csrV1beta1Client) Approve(name string) error {
+	csr, err := c.cli.CertificatesV1beta1().CertificateSigningRequests().Get(name, types.GetOptions{})
+	if err != nil {
+		return err
+	}
+	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+		Type:    capi.CertificateApproved,
+		Reason:  "AutoApproved",
+		Message: "Auto approved by TiDB Operator",
+	})
+	_, err = c.cli.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(csr)
+	if err != nil {
+		return fmt.Errorf("error updating approval for csr: %v", err)
+	}
+	return nil
+}
+
+func (c *csrV1beta1Client) Delete(name string) error {
+	return c.cli.CertificatesV1beta1().CertificateSigningRequests().Delete(name, &types.DeleteOptions{TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"}})
+}
+
+func csrV1beta1ToHandle(csr *capi.CertificateSigningRequest) *csrHandle {
+	approved := false
+	for _, cond := range csr.Status.Conditions {
+		if cond.Type == capi.CertificateApproved {
+			approved = true
+		}
+	}
+	return &csrHandle{
+		Name:        csr.Name,
+		UID:         csr.UID,
+		Labels:      csr.Labels,
+		Approved:    approved,
+		Certificate: csr.Status.Certificate,
+	}
+}
+
+// toV1Usages converts the version-agnostic usage list to v1.KeyUsage.
+func toV1Usages(usages []capi.KeyUsage) []capiv1.KeyUsage {
+	out := make([]capiv1.KeyUsage, len(usages))
+	for i, u := range usages {
+		out[i] = capiv1.KeyUsage(u)
+	}
+	return out
+}
+
+// csrV1Client implements csrClient against certificates.k8s.io/v1.
+type csrV1Client struct {
+	cli kubernetes.Interface
+}
+
+func (c *csrV1Client) Create(req *csrRequest) (*csrHandle, error) {
+	signerName := req.SignerName
+	if signerName == "" {
+		signerName = signerKubeAPIServerClient
+	}
+	usages := req.Usages
+	if len(usages) == 0 {
+		usages = usagesForSigner(signerName)
+	}
+
+	csr := &capiv1.CertificateSigningRequest{
+		TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"},
+		ObjectMeta: types.ObjectMeta{
+			Name:   req.Name,
+			Labels: req.Labels,
+		},
+		Spec: capiv1.CertificateSigningRequestSpec{
+			Request:    req.PEMRequest,
+			SignerName: signerName,
+			Usages:     toV1Usages(usages),
+		},
+	}
+	resp, err := c.cli.CertificatesV1().CertificateSigningRequests().Create(csr)
+	if err != nil {
+		return nil, err
+	}
+	return csrV1ToHandle(resp), nil
+}
+
+func (c *csrV1Client) Get(name string) (*csrHandle, error) {
+	resp, err := c.cli.CertificatesV1().CertificateSigningRequests().Get(name, types.GetOptions{TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"}})
+	if err != nil {
+		return nil, err
+	}
+	return csrV1ToHandle(resp), nil
+}
+
+func (c *csrV1Client) Decode(obj runtime.Object) (*csrHandle, bool) {
+	csr, ok := obj.(*capiv1.CertificateSigningRequest)
+	if !ok {
+		return nil, false
+	}
+	return csrV1ToHandle(csr), true
+}
+
+func (c *csrV1Client) Approve(name string) error {
+	csr, err := c.cli.CertificatesV1().CertificateSigningRequests().Get(name, types.GetOptions{})
+	if err != nil {
+		return err
+	}
+	csr.Status.Conditions = append(csr.Status.Conditions, capiv1.CertificateSigningRequestCondition{
+		Type:    capiv1.CertificateApproved,
+		Status:  corev1.ConditionTrue,
+		Reason:  "AutoApproved",
+		Message: "Auto approved by TiDB Operator",
+	})
+	_, err = c.cli.CertificatesV1().CertificateSigningRequests().UpdateApproval(name, csr, types.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("error updating approval for csr: %v", err)
+	}
+	return nil
+}
+
+func (c *csrV1Client) Delete(name string) error {
+	return c.cli.CertificatesV1().CertificateSigningRequests().Delete(name, &types.DeleteOptions{TypeMeta: types.TypeMeta{Kind: "CertificateSigningRequest"}})
+}
+
+func csrV1ToHandle(csr *capiv1.CertificateSigningRequest) *csrHandle {
+	approved := false
+	for _, cond := range csr.Status.Conditions {
+		if cond.Type == capiv1.CertificateApproved && cond.Status == corev1.ConditionTrue {
+			approved = true
+		}
+	}
+	return &csrHandle{
+		Name:        csr.Name,
+		UID:         csr.UID,
+		Labels:      csr.Labels,
+		Approved:    approved,
+		Certificate: csr.Status.Certificate,
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	capi "k8s.io/api/certificates/v1beta1"
+)
+
+func TestDefaultSignerName(t *testing.T) {
+	cases := []struct {
+		component string
+		want      string
+	}{
+		{"tikv", signerKubeletServing},
+		{"tidb", signerKubeletServing},
+		{"pd", signerKubeletServing},
+		{"ticdc", signerKubeAPIServerClient},
+		{"", signerKubeAPIServerClient},
+	}
+	for _, c := range cases {
+		if got := defaultSignerName(c.component); got != c.want {
+			t.Errorf("defaultSignerName(%q) = %q, want %q", c.component, got, c.want)
+		}
+	}
+}
+
+func TestUsagesForSigner(t *testing.T) {
+	cases := []struct {
+		signerName string
+		want       []capi.KeyUsage
+	}{
+		{signerKubeletServing, []capi.KeyUsage{capi.UsageServerAuth}},
+		{signerKubeAPIServerClient, []capi.KeyUsage{capi.UsageClientAuth}},
+		{"example.com/custom-signer", []capi.KeyUsage{capi.UsageClientAuth, capi.UsageServerAuth}},
+	}
+	for _, c := range cases {
+		got := usagesForSigner(c.signerName)
+		if len(got) != len(c.want) {
+			t.Fatalf("usagesForSigner(%q) = %v, want %v", c.signerName, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("usagesForSigner(%q) = %v, want %v", c.signerName, got, c.want)
+			}
+		}
+	}
+}
+
+func TestValidateAgainstSigner(t *testing.T) {
+	cases := []struct {
+		name       string
+		signerName string
+		hosts      []string
+		ips        []string
+		usages     []capi.KeyUsage
+		wantErr    bool
+	}{
+		{
+			name:       "kubelet-serving with SAN and exact usage passes",
+			signerName: signerKubeletServing,
+			hosts:      []string{"tikv-0.tikv-peer"},
+			usages:     []capi.KeyUsage{capi.UsageServerAuth},
+		},
+		{
+			name:       "kubelet-serving without SAN fails",
+			signerName: signerKubeletServing,
+			usages:     []capi.KeyUsage{capi.UsageServerAuth},
+			wantErr:    true,
+		},
+		{
+			name:       "kubelet-serving missing server auth fails",
+			signerName: signerKubeletServing,
+			hosts:      []string{"tikv-0.tikv-peer"},
+			usages:     []capi.KeyUsage{capi.UsageClientAuth},
+			wantErr:    true,
+		},
+		{
+			name:       "kubelet-serving with extra client auth fails",
+			signerName: signerKubeletServing,
+			hosts:      []string{"tikv-0.tikv-peer"},
+			usages:     []capi.KeyUsage{capi.UsageServerAuth, capi.UsageClientAuth},
+			wantErr:    true,
+		},
+		{
+			name:       "kube-apiserver-client with exact usage passes",
+			signerName: signerKubeAPIServerClient,
+			usages:     []capi.KeyUsage{capi.UsageClientAuth},
+		},
+		{
+			name:       "kube-apiserver-client with extra server auth fails",
+			signerName: signerKubeAPIServerClient,
+			usages:     []capi.KeyUsage{capi.UsageClientAuth, capi.UsageServerAuth},
+			wantErr:    true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateAgainstSigner(c.signerName, c.hosts, c.ips, c.usages)
+			if c.wantErr && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !c.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
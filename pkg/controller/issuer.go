@@ -0,0 +1,253 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	certutil "github.com/pingcap/tidb-operator/pkg/util/crypto"
+	capi "k8s.io/api/certificates/v1beta1"
+	types "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Issuer backend types recognized in IssuerRef.Type.
+const (
+	IssuerTypeKubernetes = "kubernetes"
+	IssuerTypeSelfSigned = "selfSigned"
+	IssuerTypeACME       = "acme"
+	IssuerTypeStepCA     = "stepCA"
+
+	defaultSelfSignedCertDuration = 365 * 24 * time.Hour
+)
+
+// IssuerRef selects which certificate issuer backend Create should use.
+type IssuerRef struct {
+	// Type is one of the IssuerType* constants. Empty defaults to
+	// IssuerTypeKubernetes.
+	Type string
+	// SecretName names the Secret Create reads issuer configuration
+	// from: a CA key+cert pair for IssuerTypeSelfSigned, or a url/token
+	// pair for IssuerTypeACME and IssuerTypeStepCA.
+	SecretName string
+}
+
+// IssuerProfile carries the parameters an Issuer needs to sign a CSR.
+type IssuerProfile struct {
+	CommonName string
+	Hosts      []string
+	IPs        []string
+	Usages     []capi.KeyUsage
+}
+
+// Issuer signs a raw (DER) certificate request and returns the signed leaf and chain, PEM-encoded.
+type Issuer interface {
+	Sign(rawCSR []byte, profile IssuerProfile) (certPEM []byte, chainPEM []byte, err error)
+}
+
+// extKeyUsagesFor maps capi.KeyUsages onto x509.ExtKeyUsage; an empty list falls back to both.
+func extKeyUsagesFor(usages []capi.KeyUsage) []x509.ExtKeyUsage {
+	var out []x509.ExtKeyUsage
+	for _, u := range usages {
+		switch u {
+		case capi.UsageClientAuth:
+			out = append(out, x509.ExtKeyUsageClientAuth)
+		case capi.UsageServerAuth:
+			out = append(out, x509.ExtKeyUsageServerAuth)
+		}
+	}
+	if len(out) == 0 {
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	}
+	return out
+}
+
+// SelfSignedIssuer signs certificates directly against a CA key+cert pair in a Secret.
+type SelfSignedIssuer struct {
+	kubeCli    kubernetes.Interface
+	ns         string
+	secretName string
+	duration   time.Duration
+}
+
+func (i *SelfSignedIssuer) Sign(rawCSR []byte, profile IssuerProfile) ([]byte, []byte, error) {
+	secret, err := i.kubeCli.CoreV1().Secrets(i.ns).Get(i.secretName, types.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA secret %s/%s: %v", i.ns, i.secretName, err)
+	}
+
+	caCertBlock, _ := pem.Decode(secret.Data["cert"])
+	if caCertBlock == nil {
+		return nil, nil, fmt.Errorf("CA secret %s/%s has no PEM certificate", i.ns, i.secretName)
+	}
+	caCert, err := x509.ParseCertificate(caCertBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate in %s/%s: %v", i.ns, i.secretName, err)
+	}
+
+	caKeyBlock, _ := pem.Decode(secret.Data["key"])
+	if caKeyBlock == nil {
+		return nil, nil, fmt.Errorf("CA secret %s/%s has no PEM key", i.ns, i.secretName)
+	}
+	caKey, err := certutil.ParsePrivateKey(caKeyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA key in %s/%s: %v", i.ns, i.secretName, err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(rawCSR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CSR: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("CSR signature check failed: %v", err)
+	}
+
+	duration := i.duration
+	if duration == 0 {
+		duration = defaultSelfSignedCertDuration
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate certificate serial: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(duration),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  extKeyUsagesFor(profile.Usages),
+	}
+
+	derCert, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derCert})
+	chainPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCertBlock.Bytes})
+	return certPEM, chainPEM, nil
+}
+
+// httpSignIssuer POSTs a PEM CSR and expects a signed cert/chain back as JSON.
+type httpSignIssuer struct {
+	kubeCli    kubernetes.Interface
+	ns         string
+	secretName string
+	httpClient *http.Client
+}
+
+type httpSignResponse struct {
+	Certificate string `json:"certificate"`
+	Chain       string `json:"chain"`
+}
+
+func (i *httpSignIssuer) sign(rawCSR []byte) ([]byte, []byte, error) {
+	secret, err := i.kubeCli.CoreV1().Secrets(i.ns).Get(i.secretName, types.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load issuer secret %s/%s: %v", i.ns, i.secretName, err)
+	}
+	url := string(secret.Data["url"])
+	if url == "" {
+		return nil, nil, fmt.Errorf("issuer secret %s/%s has no url", i.ns, i.secretName)
+	}
+	token := string(secret.Data["token"])
+
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: rawCSR})
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(csrPEM))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build request for issuer %s/%s: %v", i.ns, i.secretName, err)
+	}
+	req.Header.Set("Content-Type", "application/x-pem-file")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := i.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request to issuer %s/%s failed: %v", i.ns, i.secretName, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response from issuer %s/%s: %v", i.ns, i.secretName, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("issuer %s/%s returned status %d: %s", i.ns, i.secretName, resp.StatusCode, body)
+	}
+
+	var signed httpSignResponse
+	if err := json.Unmarshal(body, &signed); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode response from issuer %s/%s: %v", i.ns, i.secretName, err)
+	}
+	return []byte(signed.Certificate), []byte(signed.Chain), nil
+}
+
+// ACMEIssuer signs certificates through an external HTTPS signing endpoint
+// using httpSignIssuer's request format; it does not speak the ACME protocol.
+type ACMEIssuer struct {
+	httpSignIssuer
+}
+
+func (i *ACMEIssuer) Sign(rawCSR []byte, profile IssuerProfile) ([]byte, []byte, error) {
+	return i.sign(rawCSR)
+}
+
+// StepCAIssuer signs certificates through an external HTTPS signing endpoint
+// using httpSignIssuer's request format, not step-ca's own sign API.
+type StepCAIssuer struct {
+	httpSignIssuer
+}
+
+func (i *StepCAIssuer) Sign(rawCSR []byte, profile IssuerProfile) ([]byte, []byte, error) {
+	return i.sign(rawCSR)
+}
+
+// resolveIssuer builds the Issuer for opts.IssuerRef, or nil for the default CSR path.
+func (rcc *realCertControl) resolveIssuer(opts CreateOptions) (Issuer, error) {
+	ref := opts.IssuerRef
+	if ref == nil || ref.Type == "" || ref.Type == IssuerTypeKubernetes {
+		return nil, nil
+	}
+
+	switch ref.Type {
+	case IssuerTypeSelfSigned:
+		return &SelfSignedIssuer{kubeCli: rcc.kubeCli, ns: opts.Namespace, secretName: ref.SecretName}, nil
+	case IssuerTypeACME:
+		return &ACMEIssuer{httpSignIssuer{kubeCli: rcc.kubeCli, ns: opts.Namespace, secretName: ref.SecretName}}, nil
+	case IssuerTypeStepCA:
+		return &StepCAIssuer{httpSignIssuer{kubeCli: rcc.kubeCli, ns: opts.Namespace, secretName: ref.SecretName}}, nil
+	default:
+		return nil, fmt.Errorf("unknown issuer type %q", ref.Type)
+	}
+}
@@ -14,42 +14,122 @@
 package crypto
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"fmt"
 	"net"
 )
 
+// KeyAlgorithm identifies which private key algorithm NewCSRWithConfig generates.
+type KeyAlgorithm string
+
 const (
-	rsaKeySize = 2048
+	RSA     KeyAlgorithm = "RSA"
+	ECDSA   KeyAlgorithm = "ECDSA"
+	Ed25519 KeyAlgorithm = "Ed25519"
+
+	defaultRSAKeySize = 2048
 )
 
-// generate a new private key
-func newPrivateKey(size int) (*rsa.PrivateKey, error) {
-	// TODO: support more key types
-	privateKey, err := rsa.GenerateKey(rand.Reader, size)
+// KeyConfig selects the private key algorithm (and its parameters) that
+// NewCSRWithConfig generates. The zero value is equivalent to DefaultKeyConfig
+// (RSA-2048), so existing callers that don't set it keep the historical
+// behavior.
+type KeyConfig struct {
+	Algorithm KeyAlgorithm
+	// Size is the RSA modulus size in bits. Only used when Algorithm is
+	// RSA; defaults to 2048 when zero.
+	Size int
+	// Curve is the elliptic curve. Only used when Algorithm is ECDSA;
+	// defaults to elliptic.P256() when nil.
+	Curve elliptic.Curve
+}
+
+// DefaultKeyConfig is RSA-2048, NewCSR's behavior before KeyConfig
+// existed.
+var DefaultKeyConfig = KeyConfig{Algorithm: RSA, Size: defaultRSAKeySize}
+
+// generate a new private key per cfg
+func newPrivateKey(cfg KeyConfig) (crypto.Signer, error) {
+	switch cfg.Algorithm {
+	case "", RSA:
+		size := cfg.Size
+		if size == 0 {
+			size = defaultRSAKeySize
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	case ECDSA:
+		curve := cfg.Curve
+		if curve == nil {
+			curve = elliptic.P256()
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case Ed25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", cfg.Algorithm)
+	}
+}
+
+// convertKeyToPEM PKCS#8-encodes key so RSA, ECDSA and Ed25519 keys all
+// round-trip through the same "PRIVATE KEY" PEM block type.
+func convertKeyToPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
 	if err != nil {
 		return nil, err
 	}
-	return privateKey, nil
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
 }
 
-// convert private key to PEM format
-func convertKeyToPEM(blockType string, dataBytes *rsa.PrivateKey) []byte {
-	return pem.EncodeToMemory(
-		&pem.Block{
-			Type:    blockType,
-			Headers: nil,
-			Bytes:   x509.MarshalPKCS1PrivateKey(dataBytes),
-		},
-	)
+// ParsePrivateKey parses a DER-encoded private key, accepting PKCS#8
+// (used by convertKeyToPEM for all algorithms NewCSR can generate) as
+// well as legacy PKCS#1 RSA keys issued before KeyConfig existed.
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("PKCS#8 key of type %T is not a signing key", key)
+		}
+		return signer, nil
+	}
+	return x509.ParsePKCS1PrivateKey(der)
 }
 
+// KeyConfigFor reports the KeyConfig that would reproduce key's
+// algorithm (and, for ECDSA, its curve), so a cert renewal can keep
+// using the same key type as the cert it replaces.
+func KeyConfigFor(key crypto.Signer) KeyConfig {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return KeyConfig{Algorithm: ECDSA, Curve: k.Curve}
+	case ed25519.PrivateKey:
+		return KeyConfig{Algorithm: Ed25519}
+	case *rsa.PrivateKey:
+		return KeyConfig{Algorithm: RSA, Size: k.N.BitLen()}
+	default:
+		return DefaultKeyConfig
+	}
+}
+
+// NewCSR generates a new RSA-2048 private key and a matching certificate
+// request for commonName/hostList/IPList.
 func NewCSR(commonName string, hostList []string, IPList []string) ([]byte, []byte, error) {
-	// TODO: option to use an exist private key
-	privKey, err := newPrivateKey(rsaKeySize)
+	return NewCSRWithConfig(commonName, hostList, IPList, DefaultKeyConfig)
+}
+
+// NewCSRWithConfig is NewCSR with the private key algorithm selected by
+// cfg, for callers that need something other than NewCSR's RSA-2048
+// default.
+func NewCSRWithConfig(commonName string, hostList []string, IPList []string, cfg KeyConfig) ([]byte, []byte, error) {
+	privKey, err := newPrivateKey(cfg)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -75,5 +155,9 @@ func NewCSR(commonName string, hostList []string, IPList []string) ([]byte, []by
 		return nil, nil, err
 	}
 
-	return csr, convertKeyToPEM("RSA PRIVATE KEY", privKey), nil
+	keyPEM, err := convertKeyToPEM(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return csr, keyPEM, nil
 }
@@ -0,0 +1,112 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestNewCSRDefaultsToRSA2048(t *testing.T) {
+	_, keyPEM, err := NewCSR("tidb", []string{"tidb.default.svc"}, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("NewCSR returned error: %v", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		t.Fatal("NewCSR key is not valid PEM")
+	}
+	key, err := ParsePrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey failed: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", key)
+	}
+	if size := rsaKey.N.BitLen(); size != defaultRSAKeySize {
+		t.Errorf("got RSA key size %d, want %d", size, defaultRSAKeySize)
+	}
+}
+
+func TestNewCSRWithConfigRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  KeyConfig
+	}{
+		{"rsa-2048", KeyConfig{Algorithm: RSA, Size: 2048}},
+		{"ecdsa-p256", KeyConfig{Algorithm: ECDSA, Curve: elliptic.P256()}},
+		{"ed25519", KeyConfig{Algorithm: Ed25519}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, keyPEM, err := NewCSRWithConfig("tidb", []string{"tidb.default.svc"}, nil, c.cfg)
+			if err != nil {
+				t.Fatalf("NewCSRWithConfig returned error: %v", err)
+			}
+
+			block, _ := pem.Decode(keyPEM)
+			if block == nil {
+				t.Fatal("generated key is not valid PEM")
+			}
+			key, err := ParsePrivateKey(block.Bytes)
+			if err != nil {
+				t.Fatalf("ParsePrivateKey failed: %v", err)
+			}
+
+			got := KeyConfigFor(key)
+			if got.Algorithm != c.cfg.Algorithm {
+				t.Errorf("KeyConfigFor algorithm = %v, want %v", got.Algorithm, c.cfg.Algorithm)
+			}
+			if c.cfg.Algorithm == ECDSA {
+				ecKey, ok := key.(*ecdsa.PrivateKey)
+				if !ok {
+					t.Fatalf("expected *ecdsa.PrivateKey, got %T", key)
+				}
+				if ecKey.Curve != c.cfg.Curve {
+					t.Errorf("got curve %v, want %v", ecKey.Curve, c.cfg.Curve)
+				}
+			}
+			if c.cfg.Algorithm == Ed25519 {
+				if _, ok := key.(ed25519.PrivateKey); !ok {
+					t.Fatalf("expected ed25519.PrivateKey, got %T", key)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePrivateKeyLegacyPKCS1(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	der := x509.MarshalPKCS1PrivateKey(key)
+
+	parsed, err := ParsePrivateKey(der)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey failed on legacy PKCS#1 key: %v", err)
+	}
+	if _, ok := parsed.(*rsa.PrivateKey); !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", parsed)
+	}
+}